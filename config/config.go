@@ -0,0 +1,168 @@
+// Package config defines the typed, validated configuration for a secret
+// santa run and knows how to load it from a YAML file (or, as a deprecated
+// fallback, the original hand-rolled santa.txt format).
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// OAuth holds a Google OAuth2 installed-app credential set, used when
+// SMTP.Auth is "xoauth2".
+type OAuth struct {
+	ClientID     string `yaml:"client_id" json:"client_id"`
+	ClientSecret string `yaml:"client_secret" json:"client_secret"`
+	RefreshToken string `yaml:"refresh_token" json:"refresh_token"`
+}
+
+// SMTP describes how and where to send mail.
+type SMTP struct {
+	// Host is required for every Mode except "sendmail", where it is unused.
+	Host     string `yaml:"host,omitempty"`
+	Port     int    `yaml:"port"`
+	Identity string `yaml:"identity,omitempty"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password,omitempty"`
+	OAuth    *OAuth `yaml:"oauth,omitempty"`
+	From     string `yaml:"from,omitempty"` // defaults to Username
+	FromName string `yaml:"from_name,omitempty"`
+	// Mode selects the Transport: "" or "plain", "starttls", "tls", "sendmail".
+	Mode string `yaml:"mode,omitempty"`
+	// Auth selects the authentication mechanism: "" or "plain", "login", "xoauth2".
+	Auth string `yaml:"auth,omitempty"`
+	// SendmailBin is the local MTA binary to exec when Mode is "sendmail",
+	// defaulting to /usr/sbin/sendmail when empty. Unused otherwise.
+	SendmailBin string `yaml:"sendmail_bin,omitempty"`
+}
+
+// Message is the templated email sent to every giver.
+type Message struct {
+	Subject string `yaml:"subject"`
+	HTML    string `yaml:"html,omitempty"`
+	Text    string `yaml:"text,omitempty"`
+	ReplyTo string `yaml:"reply_to,omitempty"`
+}
+
+// Person is one participant.
+type Person struct {
+	Name        string   `yaml:"name"`
+	Email       string   `yaml:"email"`
+	DisplayName string   `yaml:"display_name,omitempty"`
+	Groups      []string `yaml:"groups,omitempty"`
+}
+
+// Exclusion forbids a set of people from receiving each other. Exactly one
+// of People or Group should be set: People names an explicit clique,
+// Group names a Person.Groups value whose members are mutually excluded.
+type Exclusion struct {
+	People []string `yaml:"people,omitempty"`
+	Group  string   `yaml:"group,omitempty"`
+}
+
+// PreviousAssignment is one giver->receiver pair from a past year's
+// matching, kept so it can be excluded again this year.
+type PreviousAssignment struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+}
+
+// Config is the fully-specified, validated input to a secret santa run.
+type Config struct {
+	SMTP    SMTP      `yaml:"smtp"`
+	Message Message   `yaml:"message"`
+	Seed    int64     `yaml:"seed"`
+	People  []Person  `yaml:"people"`
+
+	Exclusions []Exclusion `yaml:"exclusions,omitempty"`
+	// PreviousYears holds one matching per past year to avoid repeating.
+	PreviousYears [][]PreviousAssignment `yaml:"previous_years,omitempty"`
+}
+
+// Errors collects every validation failure found in a Config, so callers
+// can report them all at once instead of stopping at the first.
+type Errors []error
+
+func (e Errors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Validate checks c for internal consistency: duplicate people, exclusions
+// or previous-year entries that reference unknown names or groups, and
+// missing required fields. It returns an Errors holding every problem
+// found, or nil if c is valid.
+func (c *Config) Validate() error {
+	var errs Errors
+
+	names := make(map[string]bool, len(c.People))
+	emails := make(map[string]bool, len(c.People))
+	groups := make(map[string]bool)
+	for _, p := range c.People {
+		if p.Name == "" {
+			errs = append(errs, fmt.Errorf("person with email %q has no name", p.Email))
+		} else if names[p.Name] {
+			errs = append(errs, fmt.Errorf("duplicate person name %q", p.Name))
+		}
+		names[p.Name] = true
+
+		if p.Email == "" {
+			errs = append(errs, fmt.Errorf("person %q has no email", p.Name))
+		} else if emails[p.Email] {
+			errs = append(errs, fmt.Errorf("duplicate email %q", p.Email))
+		}
+		emails[p.Email] = true
+
+		for _, g := range p.Groups {
+			groups[g] = true
+		}
+	}
+
+	checkName := func(context, name string) {
+		if !names[name] {
+			errs = append(errs, fmt.Errorf("%s references unknown person %q", context, name))
+		}
+	}
+
+	for i, ex := range c.Exclusions {
+		if (len(ex.People) == 0) == (ex.Group == "") {
+			errs = append(errs, fmt.Errorf("exclusions[%d] must set exactly one of people or group", i))
+			continue
+		}
+		if ex.Group != "" && !groups[ex.Group] {
+			errs = append(errs, fmt.Errorf("exclusions[%d] references unknown group %q", i, ex.Group))
+		}
+		for _, n := range ex.People {
+			checkName(fmt.Sprintf("exclusions[%d]", i), n)
+		}
+	}
+
+	for y, year := range c.PreviousYears {
+		for i, a := range year {
+			checkName(fmt.Sprintf("previous_years[%d][%d].from", y, i), a.From)
+			checkName(fmt.Sprintf("previous_years[%d][%d].to", y, i), a.To)
+		}
+	}
+
+	if c.Message.Subject == "" {
+		errs = append(errs, errors.New("message.subject is required"))
+	}
+	if c.Message.HTML == "" && c.Message.Text == "" {
+		errs = append(errs, errors.New("message must set at least one of html or text"))
+	}
+	if c.SMTP.Host == "" && c.SMTP.Mode != "sendmail" {
+		errs = append(errs, errors.New("smtp.host is required"))
+	}
+	if c.SMTP.Auth == "xoauth2" && c.SMTP.OAuth == nil {
+		errs = append(errs, errors.New("smtp.auth xoauth2 requires smtp.oauth"))
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
@@ -0,0 +1,250 @@
+package config
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// LoadLegacy parses the original sectioned santa.txt format and maps it
+// onto a Config. It is kept only so existing santa.txt files keep working;
+// new configs should be written as YAML. Every call prints a deprecation
+// warning to stderr.
+//
+// Format (unchanged from the pre-YAML tool): four blank-line-delimited
+// sections - SMTP/message settings, the matching seed, the list of people,
+// and the (optional) list of comma-separated BadSet cliques.
+func LoadLegacy(path string) (*Config, error) {
+	fmt.Fprintf(os.Stderr, "warning: %s is in the deprecated santa.txt format; see the YAML config format instead\n", path)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	in := &legacyInput{sc: bufio.NewScanner(f)}
+
+	var c Config
+	if err := legacyReadSMTPAndMessage(in, &c); err != nil {
+		return nil, err
+	}
+
+	seedLine, ok := in.Next()
+	if !ok {
+		if err := in.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("need a line designating the seed in the second section of santa.txt")
+	}
+	seed, err := strconv.ParseInt(seedLine, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("seed: %w", err)
+	}
+	c.Seed = seed
+	if _, ok := in.Next(); ok {
+		return nil, errors.New("extra line at the end of the seed section")
+	}
+
+	if err := legacyReadPeople(in, &c); err != nil {
+		return nil, err
+	}
+	if err := legacyReadExclusions(in, &c); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+func legacyReadSMTPAndMessage(in *legacyInput, c *Config) error {
+	authLine, ok := in.Next()
+	if !ok {
+		if err := in.Err(); err != nil {
+			return err
+		}
+		return errors.New("need a line designating identity,username,password,host[,mode[,auth]] in the first line of santa.txt")
+	}
+	auths := strings.Split(authLine, ",")
+	if len(auths) < 4 || len(auths) > 6 {
+		return errors.New("need four to six fields in the first line of santa.txt: identity,username,password,host[,mode[,auth]]")
+	}
+	c.SMTP.Identity = auths[0]
+	c.SMTP.Username = auths[1]
+	c.SMTP.Password = auths[2]
+	if len(auths) >= 5 {
+		c.SMTP.Mode = auths[4]
+	}
+	if len(auths) == 6 {
+		c.SMTP.Auth = auths[5]
+	}
+	// The legacy format has no field of its own for the sendmail binary path;
+	// it reused the host field for mode=sendmail, so keep honoring that
+	// rather than putting a host requirement in front of sendmail users.
+	if c.SMTP.Mode == "sendmail" {
+		c.SMTP.SendmailBin = auths[3]
+	} else {
+		c.SMTP.Host = auths[3]
+	}
+	if c.SMTP.Auth == "xoauth2" {
+		oauth, err := parseLegacyOAuthSpec(c.SMTP.Password)
+		if err != nil {
+			return err
+		}
+		c.SMTP.OAuth = oauth
+		c.SMTP.Password = ""
+	}
+
+	emailLine, ok := in.Next()
+	if !ok {
+		if err := in.Err(); err != nil {
+			return err
+		}
+		return errors.New("need a line designating server,from[,fromName] in the second line of santa.txt")
+	}
+	sp := strings.Split(emailLine, ",")
+	if len(sp) != 2 && len(sp) != 3 {
+		return errors.New("need two or three fields in the second line of santa.txt: server,from[,fromName]")
+	}
+	if host, port, err := net.SplitHostPort(sp[0]); err == nil {
+		c.SMTP.Host = host
+		if p, err := strconv.Atoi(port); err == nil {
+			c.SMTP.Port = p
+		}
+	}
+	c.SMTP.From = sp[1]
+	if len(sp) == 3 {
+		c.SMTP.FromName = sp[2]
+	}
+
+	subject, ok := in.Next()
+	if !ok {
+		if err := in.Err(); err != nil {
+			return err
+		}
+		return errors.New("need a line designating the subject in the third line of santa.txt")
+	}
+	c.Message.Subject = subject
+
+	html, ok := in.Next()
+	if !ok {
+		if err := in.Err(); err != nil {
+			return err
+		}
+		return errors.New("need a line designating the HTML message in the fourth line of santa.txt")
+	}
+	c.Message.HTML = html
+
+	if line, ok := in.Next(); ok {
+		c.Message.Text = line
+		if line2, ok2 := in.Next(); ok2 {
+			return errors.New("extra line at the end of the first section: " + line2)
+		} else if err := in.Err(); err != nil {
+			return err
+		}
+	} else if err := in.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// parseLegacyOAuthSpec parses the "refreshtoken:clientid:clientsecret" or
+// "@path/to/creds.json" convention the legacy format uses to cram OAuth2
+// credentials into the password field.
+func parseLegacyOAuthSpec(spec string) (*OAuth, error) {
+	if path, ok := strings.CutPrefix(spec, "@"); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var o OAuth
+		if err := json.Unmarshal(data, &o); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+		return &o, nil
+	}
+	parts := strings.SplitN(spec, ":", 3)
+	if len(parts) != 3 {
+		return nil, errors.New(`xoauth2 credentials must be "refreshtoken:clientid:clientsecret" or "@path/to/creds.json"`)
+	}
+	return &OAuth{RefreshToken: parts[0], ClientID: parts[1], ClientSecret: parts[2]}, nil
+}
+
+func legacyReadPeople(in *legacyInput, c *Config) error {
+	for line, ok := in.Next(); ok; line, ok = in.Next() {
+		colon := strings.Index(line, ":")
+		if colon == -1 {
+			return fmt.Errorf("couldn't find email address in %q", line)
+		}
+		name := strings.TrimSpace(line[:colon])
+		addr := strings.TrimSpace(line[colon+1:])
+
+		p := Person{Name: name, Email: addr}
+		if dn, email, ok := splitDisplayName(addr); ok {
+			p.DisplayName = dn
+			p.Email = email
+		}
+		c.People = append(c.People, p)
+	}
+	return in.Err()
+}
+
+// splitDisplayName recognizes the legacy "Full Name <email>" syntax.
+func splitDisplayName(addr string) (name, email string, ok bool) {
+	lt := strings.LastIndex(addr, "<")
+	gt := strings.LastIndex(addr, ">")
+	if lt == -1 || gt == -1 || gt < lt {
+		return "", "", false
+	}
+	return strings.TrimSpace(addr[:lt]), strings.TrimSpace(addr[lt+1 : gt]), true
+}
+
+func legacyReadExclusions(in *legacyInput, c *Config) error {
+	for line, ok := in.Next(); ok; line, ok = in.Next() {
+		var names []string
+		for _, p := range strings.Split(line, ",") {
+			// Names must match a People entry verbatim. The original parser
+			// ran strings.Title here, which silently broke matching for any
+			// name with a lowercase particle (e.g. "van Helsing" became "Van
+			// Helsing"); Validate now catches any exclusion that still
+			// doesn't match a known person instead of dropping it silently.
+			names = append(names, strings.TrimSpace(p))
+		}
+		c.Exclusions = append(c.Exclusions, Exclusion{People: names})
+	}
+	return in.Err()
+}
+
+// legacyInput replays the original Input scanner: blank lines delimit
+// sections, "//"-prefixed lines are comments.
+type legacyInput struct {
+	sc      *bufio.Scanner
+	atLines bool
+}
+
+func (i *legacyInput) Next() (string, bool) {
+	for i.sc.Scan() {
+		line := strings.Trim(i.sc.Text(), " \t")
+		if strings.HasPrefix(line, "//") {
+			continue
+		}
+		if len(line) > 0 {
+			i.atLines = true
+			return line, true
+		}
+		if i.atLines {
+			i.atLines = false
+			return "", false
+		}
+	}
+	return "", false
+}
+
+func (i *legacyInput) Err() error {
+	return i.sc.Err()
+}
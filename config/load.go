@@ -0,0 +1,39 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Load reads and validates the config at path. Files named *.yaml or *.yml
+// are parsed as the typed YAML format; anything else is assumed to be the
+// original sectioned santa.txt format and handled by the deprecated
+// LoadLegacy fallback. Either way, callers only ever see a validated
+// Config.
+func Load(path string) (*Config, error) {
+	var c Config
+	switch ext := filepath.Ext(path); ext {
+	case ".yaml", ".yml":
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(data, &c); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+	default:
+		legacy, err := LoadLegacy(path)
+		if err != nil {
+			return nil, err
+		}
+		c = *legacy
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// JournalEntry records one successfully delivered assignment.
+type JournalEntry struct {
+	FromEmail string `json:"from_email"`
+	ToEmail   string `json:"to_email"`
+	MessageID string `json:"message_id"`
+	Timestamp string `json:"timestamp"`
+}
+
+// Journal is an append-only, line-delimited JSON log of every assignment
+// BatchSend has successfully delivered, so a run interrupted partway through
+// (a crash, an SMTP outage) can be resumed without re-sending or re-matching.
+type Journal struct {
+	mu      sync.Mutex
+	f       *os.File
+	entries []JournalEntry
+}
+
+// OpenJournal opens (creating if necessary) the journal at path, replaying
+// any entries already in it.
+func OpenJournal(path string) (*Journal, error) {
+	var entries []JournalEntry
+	if data, err := os.ReadFile(path); err == nil {
+		sc := bufio.NewScanner(bytes.NewReader(data))
+		for sc.Scan() {
+			line := bytes.TrimSpace(sc.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			var e JournalEntry
+			if err := json.Unmarshal(line, &e); err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", path, err)
+			}
+			entries = append(entries, e)
+		}
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{f: f, entries: entries}, nil
+}
+
+// Append atomically records e: a single Write of a complete line is atomic
+// for a regular file opened O_APPEND, and Sync makes sure it survives a
+// crash before the next assignment starts.
+func (j *Journal) Append(e JournalEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.f.Write(data); err != nil {
+		return err
+	}
+	if err := j.f.Sync(); err != nil {
+		return err
+	}
+	j.entries = append(j.entries, e)
+	return nil
+}
+
+// Entries returns a snapshot of every entry recorded so far.
+func (j *Journal) Entries() []JournalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return append([]JournalEntry(nil), j.entries...)
+}
+
+func (j *Journal) Close() error {
+	return j.f.Close()
+}
+
+// pairKey identifies one giver->receiver assignment by email address, the
+// same identity the journal and the solved matching are compared by.
+type pairKey struct {
+	from, to string
+}
+
+// solutionPairs indexes a solved matching by pairKey for diffing against a
+// journal.
+func solutionPairs(solution map[*Person]*Person) map[pairKey]bool {
+	pairs := make(map[pairKey]bool, len(solution))
+	for from, to := range solution {
+		pairs[pairKey{from.email, to.email}] = true
+	}
+	return pairs
+}
+
+// journalDiff splits entries against solution: sent holds the entries whose
+// pair is part of solution (so can be skipped on resume), mismatched holds
+// entries whose pair isn't in solution at all, meaning the journal is from a
+// different matching than the one just solved.
+func journalDiff(entries []JournalEntry, solution map[*Person]*Person) (sent map[pairKey]JournalEntry, mismatched []JournalEntry) {
+	pairs := solutionPairs(solution)
+	sent = make(map[pairKey]JournalEntry)
+	for _, e := range entries {
+		k := pairKey{e.FromEmail, e.ToEmail}
+		if !pairs[k] {
+			mismatched = append(mismatched, e)
+			continue
+		}
+		sent[k] = e
+	}
+	return sent, mismatched
+}
+
+// printDiff prints the sent/pending split of solution against sent, for
+// --dry-run-diff.
+func printDiff(solution map[*Person]*Person, sent map[pairKey]JournalEntry) {
+	fmt.Println("Already sent:")
+	for k, e := range sent {
+		fmt.Printf("  %s -> %s (message-id %s, sent %s)\n", k.from, k.to, e.MessageID, e.Timestamp)
+	}
+	fmt.Println("Pending:")
+	for from, to := range solution {
+		k := pairKey{from.email, to.email}
+		if _, ok := sent[k]; !ok {
+			fmt.Printf("  %s -> %s\n", from.email, to.email)
+		}
+	}
+}
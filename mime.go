@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"html"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// buildMessage composes a full RFC 5322 message with a multipart/alternative
+// body (plain text fallback plus the HTML message), RFC 2047 encoded-word
+// headers where needed, and a fresh Message-ID. replyTo is optional; when
+// empty, no Reply-To header is written. It returns the raw message ready to
+// hand to a Transport, along with the Message-ID it generated so callers can
+// record it (e.g. in a send journal).
+func buildMessage(from, to mail.Address, subject, textBody, htmlBody, replyTo string) ([]byte, string) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	writeAlternative(mw, "text/plain", textBody)
+	writeAlternative(mw, "text/html", htmlBody)
+	mw.Close()
+
+	msgID := newMessageID(addrDomain(from.Address))
+
+	var hdr bytes.Buffer
+	fmt.Fprintf(&hdr, "From: %s\r\n", from.String())
+	fmt.Fprintf(&hdr, "To: %s\r\n", to.String())
+	if replyTo != "" {
+		fmt.Fprintf(&hdr, "Reply-To: %s\r\n", replyTo)
+	}
+	fmt.Fprintf(&hdr, "Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", subject))
+	fmt.Fprintf(&hdr, "Message-ID: %s\r\n", msgID)
+	fmt.Fprintf(&hdr, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	hdr.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&hdr, "Content-Type: multipart/alternative; boundary=%q\r\n", mw.Boundary())
+	hdr.WriteString("\r\n")
+	hdr.Write(body.Bytes())
+
+	return hdr.Bytes(), msgID
+}
+
+func writeAlternative(mw *multipart.Writer, contentType, content string) {
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Type", contentType+"; charset=UTF-8")
+	h.Set("Content-Transfer-Encoding", "quoted-printable")
+	pw, err := mw.CreatePart(h)
+	if err != nil {
+		// CreatePart only fails if the underlying writer fails, which for an
+		// in-memory bytes.Buffer never happens.
+		panic(err)
+	}
+	qw := quotedprintable.NewWriter(pw)
+	qw.Write([]byte(content))
+	qw.Close()
+}
+
+// newMessageID returns an RFC 5322 Message-ID of the form <random@domain>.
+func newMessageID(domain string) string {
+	var raw [16]byte
+	rand.Read(raw[:])
+	return fmt.Sprintf("<%x@%s>", raw, domain)
+}
+
+func addrDomain(email string) string {
+	if at := strings.LastIndex(email, "@"); at != -1 {
+		return email[at+1:]
+	}
+	return "localhost"
+}
+
+var htmlTagRE = regexp.MustCompile(`<[^>]*>`)
+
+// derivePlainText produces a best-effort plain-text fallback from an HTML
+// message body, for configs that only supply one template.
+func derivePlainText(htmlBody string) string {
+	return html.UnescapeString(htmlTagRE.ReplaceAllString(htmlBody, ""))
+}
+
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/Carrotman42/secret-santa/config"
+)
+
+// isAuthFailure reports whether err is an SMTP 535 (authentication failed)
+// response, the signal that an OAuth2 access token has expired and needs
+// refreshing before the next retry.
+func isAuthFailure(err error) bool {
+	var tperr *textproto.Error
+	return errors.As(err, &tperr) && tperr.Code == 535
+}
+
+// xoauth2Auth implements smtp.Auth for the XOAUTH2 SASL mechanism that
+// Gmail and Microsoft 365 require now that basic password auth is disabled
+// for SMTP submission.
+type xoauth2Auth struct {
+	username string
+	token    string
+}
+
+func (a *xoauth2Auth) Start(_ *smtp.ServerInfo) (string, []byte, error) {
+	resp := []byte("user=" + a.username + "\x01auth=Bearer " + a.token + "\x01\x01")
+	return "XOAUTH2", resp, nil
+}
+
+func (a *xoauth2Auth) Next(_ []byte, more bool) ([]byte, error) {
+	if more {
+		// The server is reporting a SASL error as a challenge; an empty
+		// response lets it fail the exchange cleanly instead of hanging.
+		return []byte{}, nil
+	}
+	return nil, nil
+}
+
+// loginAuth implements the non-standard but widely supported SMTP LOGIN
+// mechanism that some providers, notably Office 365, prefer over PLAIN.
+type loginAuth struct {
+	username, password string
+}
+
+func (a *loginAuth) Start(_ *smtp.ServerInfo) (string, []byte, error) {
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(string(fromServer)) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected LOGIN challenge: %q", fromServer)
+	}
+}
+
+// Authenticator supplies the smtp.Auth to use on a connection and knows how
+// to refresh itself after an AUTH failure, e.g. an expired OAuth2 access
+// token.
+type Authenticator interface {
+	Auth() (smtp.Auth, error)
+	Refresh() error
+}
+
+// staticAuth wraps a fixed smtp.Auth (the "plain" and "login" modes), which
+// has nothing to refresh.
+type staticAuth struct{ auth smtp.Auth }
+
+func (s *staticAuth) Auth() (smtp.Auth, error) { return s.auth, nil }
+func (s *staticAuth) Refresh() error           { return errors.New("credentials cannot be refreshed") }
+
+// OAuthCreds is a Google OAuth2 installed-app credential set: a long-lived
+// refresh token plus the client ID/secret it was issued under.
+type OAuthCreds struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// FetchAccessToken exchanges the refresh token for a fresh access token
+// against Google's OAuth2 token endpoint.
+func (c *OAuthCreds) FetchAccessToken() (string, error) {
+	form := url.Values{
+		"client_id":     {c.ClientID},
+		"client_secret": {c.ClientSecret},
+		"refresh_token": {c.RefreshToken},
+		"grant_type":    {"refresh_token"},
+	}
+	resp, err := http.PostForm("https://oauth2.googleapis.com/token", form)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", err
+	}
+	if out.Error != "" {
+		return "", fmt.Errorf("oauth2 token refresh failed: %s: %s", out.Error, out.ErrorDescription)
+	}
+	if out.AccessToken == "" {
+		return "", errors.New("oauth2 token refresh returned no access_token")
+	}
+	return out.AccessToken, nil
+}
+
+// oauthAuthenticator wraps OAuthCreds with the current access token,
+// fetching one up front and fetching another on demand when Refresh is
+// called after a 535 from the server. BatchSend runs several workers
+// against a single Authenticator, so Auth and Refresh can race; mu guards
+// token against that.
+type oauthAuthenticator struct {
+	username string
+	creds    *OAuthCreds
+
+	mu    sync.Mutex
+	token string
+}
+
+func newOAuthAuthenticator(username string, creds *OAuthCreds) (*oauthAuthenticator, error) {
+	a := &oauthAuthenticator{username: username, creds: creds}
+	if err := a.Refresh(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *oauthAuthenticator) Auth() (smtp.Auth, error) {
+	a.mu.Lock()
+	token := a.token
+	a.mu.Unlock()
+	return &xoauth2Auth{username: a.username, token: token}, nil
+}
+
+func (a *oauthAuthenticator) Refresh() error {
+	token, err := a.creds.FetchAccessToken()
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.token = token
+	a.mu.Unlock()
+	return nil
+}
+
+// buildAuthenticatorForConfig selects the Authenticator named by
+// sm.Auth ("" or "plain", "login", "xoauth2"), using the rest of sm as its
+// credentials.
+func buildAuthenticatorForConfig(sm config.SMTP) (Authenticator, error) {
+	switch sm.Auth {
+	case "", "plain":
+		return &staticAuth{auth: smtp.PlainAuth(sm.Identity, sm.Username, sm.Password, sm.Host)}, nil
+	case "login":
+		return &staticAuth{auth: &loginAuth{username: sm.Username, password: sm.Password}}, nil
+	case "xoauth2":
+		if sm.OAuth == nil {
+			return nil, errors.New("smtp.auth xoauth2 requires smtp.oauth")
+		}
+		creds := &OAuthCreds{ClientID: sm.OAuth.ClientID, ClientSecret: sm.OAuth.ClientSecret, RefreshToken: sm.OAuth.RefreshToken}
+		return newOAuthAuthenticator(sm.Username, creds)
+	default:
+		return nil, fmt.Errorf("unknown smtp auth mode %q: must be plain, login, or xoauth2", sm.Auth)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net/smtp"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/Carrotman42/secret-santa/config"
+)
+
+// reloadableTransport lets a SIGHUP swap in a freshly built Transport
+// without disturbing BatchSend's workers: they each hold their own copy of
+// the Emailer, but all of those copies share the same *reloadableTransport
+// pointer, so a Set here is visible to every in-flight worker.
+type reloadableTransport struct {
+	mu sync.RWMutex
+	t  Transport
+}
+
+func (r *reloadableTransport) Send(from string, to []string, msg []byte) error {
+	r.mu.RLock()
+	t := r.t
+	r.mu.RUnlock()
+	return t.Send(from, to, msg)
+}
+
+func (r *reloadableTransport) set(t Transport) {
+	r.mu.Lock()
+	r.t = t
+	r.mu.Unlock()
+}
+
+// reloadableAuthn is reloadableTransport's counterpart for Authenticator.
+type reloadableAuthn struct {
+	mu sync.RWMutex
+	a  Authenticator
+}
+
+func (r *reloadableAuthn) Auth() (smtp.Auth, error) {
+	r.mu.RLock()
+	a := r.a
+	r.mu.RUnlock()
+	return a.Auth()
+}
+
+func (r *reloadableAuthn) Refresh() error {
+	r.mu.RLock()
+	a := r.a
+	r.mu.RUnlock()
+	return a.Refresh()
+}
+
+func (r *reloadableAuthn) set(a Authenticator) {
+	r.mu.Lock()
+	r.a = a
+	r.mu.Unlock()
+}
+
+// reload rebuilds e's transport and authenticator from cfg's smtp section
+// and swaps them in, so retries after an outage pick up new TLS settings or
+// credentials without restarting the process.
+func (e *Emailer) reload(cfg *config.Config) error {
+	authn, err := buildAuthenticatorForConfig(cfg.SMTP)
+	if err != nil {
+		return err
+	}
+	server := cfg.SMTP.Host
+	if cfg.SMTP.Port != 0 {
+		server = fmt.Sprintf("%s:%d", cfg.SMTP.Host, cfg.SMTP.Port)
+	}
+	transport, err := newTransport(cfg.SMTP.Mode, authn, cfg.SMTP.Host, server, cfg.SMTP.SendmailBin)
+	if err != nil {
+		return err
+	}
+	e.transport.(*reloadableTransport).set(transport)
+	e.authn.(*reloadableAuthn).set(authn)
+	return nil
+}
+
+// installReloadHandler installs a SIGHUP handler that re-reads path and
+// calls e.reload, the way the mailpopbox server reloads its TLS certificate
+// on SIGHUP without dropping connections. A failed reload logs and leaves
+// the previous transport/authenticator in place.
+func installReloadHandler(path string, e *Emailer) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	go func() {
+		for range sig {
+			fmt.Println("SIGHUP received, reloading SMTP settings from", path)
+			cfg, err := config.Load(path)
+			if err != nil {
+				fmt.Println("reload failed:", err)
+				continue
+			}
+			if err := e.reload(cfg); err != nil {
+				fmt.Println("reload failed:", err)
+				continue
+			}
+			fmt.Println("reload complete")
+		}
+	}()
+}
@@ -2,113 +2,60 @@ package main
 
 import (
 	"fmt"
-	"math/rand"
 	"bufio"
+	"flag"
+	"net/mail"
+	"os"
 	"strings"
 	"errors"
-	"os"
-	"net/smtp"
 	"sync"
 	"time"
-	"sort"
-	"strconv"
+
+	"github.com/Carrotman42/secret-santa/config"
 )
 
-// Format of input file (named santa.txt):
-//   Designated by four sections. Each section is defined by a set of newline-delimited data.
-//   Each section ends with at least one empty line. In addition, the first section may optionally
-//   be preceeded by an arbitrary amount of blank lines.
-//
-//   Section one:
-//      Contains four lines:
-//         1. Comma separated values for authentification of the sender of the emails:
-//                 	identity,username,password,host
-//              Note: Because it is csv list, none of the values may contain a comma
-//         2. Comma separated values for email information:
-//                   server,from
-//         3. The subject of the email
-//         4. A generic message for the email, where the following macros are supported:
-//                   %1: Name of gift sender
-//                   %2: Email of gift sender
-//                   %3: Name of gift receiver
-//   Section two:
-//      Contains one line: the seed of the random matching. Must fit into a int64.
-//   Section three:
-//      A list of people in the secret santa in the following format:
-//               name:emailaddress
-//      Note: Names must not contain commas
-//   Section four:
-//      A list of sets of people who may not receive each other as matchings. Each set is
-//      represented by a csv list. Note that names must be (almost) exactly the same as they
-//      appear in section three. The almost comes from the fact that in this section, names
-//      are run through strings.Title first.
-//
-//      This section is optional.
+// Config is read from santa.yaml in the current directory, or - if that
+// doesn't exist - from the original sectioned santa.txt format (deprecated;
+// see config.LoadLegacy). Either way, main only ever sees a config.Config
+// that has already passed config.Config.Validate.
+const configFile = "santa.yaml"
+const legacyConfigFile = "santa.txt"
+
+// journalFile records every assignment BatchSend has successfully sent, so
+// an interrupted run can be resumed without double-sending. See journal.go.
+const journalFile = "santa-journal.json"
+
+var (
+	// Pairs the journal already records as sent are always skipped; --resume
+	// additionally skips the interactive confirmation prompt, for unattended
+	// retries after an outage.
+	resume     = flag.Bool("resume", false, "skip the confirmation prompt; already-sent pairs are always skipped automatically")
+	dryRunDiff = flag.Bool("dry-run-diff", false, "print the sent/pending split against the send journal and exit without sending anything")
+	force      = flag.Bool("force", false, "continue even if the send journal has entries that don't match the resolved matching")
+)
 
 // If set to true, the emailer will instead send all of the matching emails to itself. Use
-//   this to test that you set up section four correctly.
+//   this to test that you set up the exclusions correctly.
 const DRY_RUN = false
 
-type Input struct {
-	sc *bufio.Scanner
-	atLines bool
-}
-
-func (i*Input) Next() (string, bool) {
-	for i.sc.Scan() {
-		line := strings.Trim(i.sc.Text(), " \t")
-		if strings.HasPrefix(line, "//") {
-			continue
-		}
-		if len(line) > 0 {
-			i.atLines = true
-			return line, true
-		}
-		if i.atLines {
-			i.atLines = false
-			return "", false
-		}
-		// we weren't at lines already, so keep reading until we get some lines
-	}
-	return "", false
-}
-
-func (i*Input) Err() error {
-	return i.sc.Err()
-}
-
 type Person struct {
 	name string
 	email string
-
-	good []*Person
+	displayName string // optional; falls back to name when empty
 }
 
 func (p*Person) String() string {
 	return p.name
 }
 
-func readInPeople(in *Input) (map[string]*Person, error) {
-	ret := make(map[string]*Person)
-	for line, ok := in.Next(); ok; line, ok = in.Next(){
-		colon := strings.Index(line, ":")
-		if colon == -1 {
-			return nil, errors.New(fmt.Sprint("Couldn't find email address in", line))
-		}
-		
-		name, email := strings.Trim(line[:colon], " \t"), strings.Trim(line[colon+1:], " \t")
-		
-		ret[name] = &Person{
-			name: name,
-			email: email,
-			good: nil,
-		}
+// Addr returns p as a mail.Address suitable for a From:/To: header, encoding
+// the display name per RFC 2047 if needed.
+func (p*Person) Addr() mail.Address {
+	dn := p.displayName
+	if dn == "" {
+		dn = p.name
 	}
-	
-	if err := in.Err(); err != nil {
-		return nil, err
-	}
-	return ret, nil
+	return mail.Address{Name: dn, Address: p.email}
 }
 
 type B struct{}
@@ -142,23 +89,6 @@ func (b *BadSet) String() string {
 	return fmt.Sprint(b.pbad)
 }
 
-func readInBadSets(in *Input, people map[string]*Person) ([]*BadSet, error) {
-	ret := make([]*BadSet, 0, 10)
-	for line, ok := in.Next(); ok; line, ok = in.Next() {
-		set := NewBadSet()
-		ret = append(ret, set)
-		
-		for _, p := range strings.Split(line, ",") {
-			p = strings.Title(p)
-			set.AddPerson(people[p])
-		}
-	}
-	if err := in.Err(); err != nil {
-		return nil, err
-	}
-	return ret, nil
-}
-
 func compileBadSet(bads []*BadSet) map[*Person]*BadSet {
 	ret := make(map[*Person]*BadSet)
 	for _,v := range bads {
@@ -174,158 +104,153 @@ func compileBadSet(bads []*BadSet) map[*Person]*BadSet {
 	return ret
 }
 
-func makeDomain(cur *Person, all map[string]*Person, bad *BadSet) []*Person {
-	ret := make([]*Person, 0, len(all)/2)
-	for _,v := range all {
-		if bad == nil {
-			// Didn't belong to any badsets, so just make sure they can't get themself
-			if v == cur {
-				continue
-			}
-		} else if bad.IsIn(v) {
-			// Only add them to the set if the person isn't in the badset
-			continue
-		}
-		ret = append(ret, v)
+// peopleFromConfig builds the name-keyed Person map the solver works with.
+// c is assumed to have already passed Validate, so names/emails are unique.
+func peopleFromConfig(c *config.Config) map[string]*Person {
+	people := make(map[string]*Person, len(c.People))
+	for _, cp := range c.People {
+		people[cp.Name] = &Person{name: cp.Name, email: cp.Email, displayName: cp.DisplayName}
 	}
-	return ret
+	return people
 }
 
-type People []*Person
-
-func (p People) Len() int { return len(p) }
-func (p People) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
-func (p People) Less(i, j int) bool { return p[i].name < p[j].name }
-
-func shuffle(l []*Person, r *rand.Rand) {
-	// First sort them so that they are in a deterministic order so that the random assignment can be re-encountered if needed
-	sort.Sort(People(l))
-	for i := int32(len(l) - 1); i > 0; i-- {
-		j := r.Int31n(i + 1)
-		l[i], l[j] = l[j], l[i]
+// badSetsFromConfig translates c.Exclusions into BadSets: one clique per
+// explicit People list, plus one per referenced group, gathering that
+// group's members from c.People.
+func badSetsFromConfig(c *config.Config, people map[string]*Person) []*BadSet {
+	groups := make(map[string][]*Person)
+	for _, cp := range c.People {
+		for _, g := range cp.Groups {
+			groups[g] = append(groups[g], people[cp.Name])
+		}
 	}
-}
 
-func buildDomains(people map[string]*Person, bad []*BadSet, r *rand.Rand) []*Person {
-	badMap := compileBadSet(bad)
-	ret := make([]*Person, len(people))
-	i := 0
-	for _,v := range people {
-		ret[i] = v
-		i++
-	}
-	// Sort so that we have deterministic output
-	sort.Sort(People(ret))
-	for _,v := range ret {
-		v.good = makeDomain(v, people, badMap[v])
-		shuffle(v.good, r)
-		fmt.Println("Domain for", v, ":", v.good)
+	ret := make([]*BadSet, 0, len(c.Exclusions))
+	for _, ex := range c.Exclusions {
+		set := NewBadSet()
+		if ex.Group != "" {
+			for _, p := range groups[ex.Group] {
+				set.AddPerson(p)
+			}
+		}
+		for _, name := range ex.People {
+			set.AddPerson(people[name])
+		}
+		ret = append(ret, set)
 	}
 	return ret
 }
 
-func solve(all []*Person, cur int, assigned map[*Person]B) map[*Person]*Person {
-	if cur == len(all) {
-		// Got to the end without problems!
-		return make(map[*Person]*Person, len(all))
-	}
-	
-	c := all[cur]
-	next := cur + 1
-	for _,try := range c.good {
-		if _,ok := assigned[try]; ok {
-			// already assigned
-			continue
+// applyPreviousYears clears the receiver bit for each past giver->receiver
+// pair directly out of c's domains, so this year's matching can't repeat
+// one. Unlike exclusions/groups this is a one-directional pairing, not a
+// mutual clique, so it's applied straight to the bitsets rather than
+// through a BadSet.
+func applyPreviousYears(c *csp, people map[string]*Person, years [][]config.PreviousAssignment) {
+	index := make(map[*Person]int, len(c.people))
+	for i, p := range c.people {
+		index[p] = i
+	}
+	for _, year := range years {
+		for _, a := range year {
+			from, to := people[a.From], people[a.To]
+			if from == nil || to == nil {
+				continue
+			}
+			c.domains[index[from]].SetBit(c.domains[index[from]], index[to], 0)
 		}
-		
-		// Try to assign this one
-		assigned[try] = B{}
-		if r := solve(all, next, assigned); r != nil {
-			// This assignment was correct! Record our match and return
-			r[c] = try
-			return r
-		} // else: guess caused problems. Try again.
-		
-		delete(assigned, try)
-	}
-	
-	// Domain is zero - no solution!
-	return nil
+	}
 }
 
+func main() {
+	flag.Parse()
 
-func makeRand(seed int64) *rand.Rand {
-	return rand.New(rand.NewSource(seed))
-}
+	path := configFile
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		path = legacyConfigFile
+	}
 
-func main() {
-	f, err := os.Open("santa.txt")
+	cfg, err := config.Load(path)
 	if err != nil {
 		panic(err)
 	}
-	defer f.Close()
-	
-	in := &Input{sc:bufio.NewScanner(f),}
-	
-	emailer,err := readInEmailer(in)
+
+	emailer, err := emailerFromConfig(cfg)
 	if err != nil {
 		panic(err)
 	}
-	
-	var seed int64
-	strSeed,_ := in.Next()
-	if seed, err = strconv.ParseInt(strSeed, 10, 64); err != nil {
-		panic(errors.New("Need a line designating the seed in the second section of the page" + err.Error()))
-	}
-	if _, b := in.Next(); b {
-		panic(errors.New("Extra line at the end of the seed section"))
-	}
-	
-	peoples, err := readInPeople(in)
+	installReloadHandler(path, emailer)
+
+	journal, err := OpenJournal(journalFile)
 	if err != nil {
 		panic(err)
 	}
-	
-	fmt.Println("Found", len(peoples), "people")
-	
-	rawbads, err := readInBadSets(in, peoples)
-	if err != nil {
-		panic(err)
+	defer journal.Close()
+	emailer.journal = journal
+
+	fmt.Println("Found", len(cfg.People), "people")
+
+	people := peopleFromConfig(cfg)
+	badSets := badSetsFromConfig(cfg, people)
+
+	theCSP := buildCSP(people, badSets)
+	applyPreviousYears(theCSP, people, cfg.PreviousYears)
+	if !hallFeasible(theCSP.domains) {
+		panic(errors.New("No possible matching exists for the given exclusions (failed Hall's theorem feasibility check)"))
+	}
+
+	solution := solve(theCSP, makeRand(cfg.Seed))
+	if solution == nil {
+		panic(errors.New("Could not find a solution with the given parameters!"))
+	}
+
+	sent, mismatched := journalDiff(journal.Entries(), solution)
+	if len(mismatched) > 0 && !*force {
+		panic(fmt.Errorf("send journal %s has %d entries that don't match the resolved matching (e.g. %s -> %s); pass --force to continue anyway", journalFile, len(mismatched), mismatched[0].FromEmail, mismatched[0].ToEmail))
+	}
+
+	if *dryRunDiff {
+		printDiff(solution, sent)
+		return
+	}
+
+	if len(sent) > 0 {
+		fmt.Printf("Resuming from %s: skipping %d pair(s) already sent\n", journalFile, len(sent))
 	}
-	
-	people := buildDomains(peoples, rawbads, makeRand(seed))
-	
+
 	if DRY_RUN {
 		fmt.Println("Doing a dry-run of matching!")
+	} else if *resume {
+		// --resume means the operator already confirmed this run once; don't
+		// make an unattended retry-after-outage wait on stdin again.
+		fmt.Println("--resume given, skipping the confirmation prompt")
 	} else {
 		sc := bufio.NewScanner(os.Stdin)
-		
-		fmt.Println("WARNING: This is about to send out emails to everyone involved in the secret santa event. Please make sure that you have permission to email all of the people in the santa.txt file and that you are meaning to do this.")
+
+		fmt.Println("WARNING: This is about to send out emails to everyone involved in the secret santa event. Please make sure that you have permission to email all of the people in the config and that you are meaning to do this.")
 		fmt.Println()
 		fmt.Println("To continue press enter. To cancel press ctrl-c.")
 		sc.Scan()
 	}
-	
-	solution := solve(people, 0, make(map[*Person]B))
-	if solution == nil {
-		panic(errors.New("Could not find a solution with the given parameters!"))
-	}
-	
+
 	// Shouldn't try to send too many emails at a time. Limit to four conncurrent sends.
 	out, wait := BatchSend(4, *emailer)
 	for k,v := range solution {
+		if _, done := sent[pairKey{k.email, v.email}]; done {
+			continue
+		}
 		out <- Assignment{k, v}
 	}
 	close(out)
 	wait.Wait()
-	
+
 	fmt.Println("Done!")
 }
 
 func BatchSend(workers int, e Emailer) (chan<-Assignment, *sync.WaitGroup) {
 	var done sync.WaitGroup
 	done.Add(workers)
-	
+
 	ret := make(chan Assignment, 4)
 	ffunc := func() {
 		for ii := range ret {
@@ -334,6 +259,11 @@ func BatchSend(workers int, e Emailer) (chan<-Assignment, *sync.WaitGroup) {
 				err := e.sendAssignment(k, v)
 				if err != nil {
 					fmt.Println("Error sending assignment to", k, ":", err)
+					if e.authn != nil && isAuthFailure(err) {
+						if rerr := e.authn.Refresh(); rerr != nil {
+							fmt.Println("Could not refresh credentials:", rerr)
+						}
+					}
 					<-time.After(time.Second)
 				} else {
 					fmt.Println("Sent", k)//, ":", v)
@@ -343,11 +273,11 @@ func BatchSend(workers int, e Emailer) (chan<-Assignment, *sync.WaitGroup) {
 		}
 		done.Done()
 	}
-	
+
 	for i := 0; i < workers; i++ {
 		go ffunc()
 	}
-	
+
 	return ret, &done
 }
 
@@ -355,79 +285,109 @@ type Assignment struct {
 	src, dest *Person
 }
 
-func (e Emailer) sendAssignment(src, dest *Person) error {
+func expandMacros(tmpl string, src, dest *Person) string {
 	// %1: Name of gift sender
 	// %2: Email of gift sender
 	// %3: Name of gift receiver
-	msg := strings.Replace(
+	return strings.Replace(
 				strings.Replace(
-					strings.Replace(e.rawMsg, "%1", src.name, -1),
+					strings.Replace(tmpl, "%1", src.name, -1),
 					"%2", src.email, -1),
 				"%3", dest.name, -1)
+}
 
-	var to string
+func (e Emailer) sendAssignment(src, dest *Person) error {
+	html := expandMacros(e.htmlMsg, src, dest)
+	text := expandMacros(e.textMsg, src, dest)
+
+	toPerson := src
 	if DRY_RUN {
-		to = e.from
-	} else {
-		to = src.email
+		toPerson = &Person{name: src.name, email: e.from}
 	}
 
-	return smtp.SendMail(e.server, e.auth, e.from, []string{to}, ([]byte)(msg))
-}
+	msg, msgID := buildMessage(e.fromAddr, toPerson.Addr(), e.subject, text, html, e.replyTo)
 
-type Emailer struct {
-	auth smtp.Auth
-	server, from string
-	rawMsg string
-}
+	if err := e.transport.Send(e.from, []string{toPerson.email}, msg); err != nil {
+		return err
+	}
 
-func readLine(in*Input, exp int, errString string) ([]string, error) {
-	if l, b := in.Next(); !b {
-		if e := in.Err(); e != nil {
-			return nil, e
+	if e.journal != nil {
+		entry := JournalEntry{
+			FromEmail: src.email,
+			ToEmail:   dest.email,
+			MessageID: msgID,
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+		}
+		if err := e.journal.Append(entry); err != nil {
+			fmt.Println("warning: could not record journal entry for", toPerson, ":", err)
 		}
-	} else if exp == -1 {
-		return []string{l}, nil
-	} else if sp := strings.Split(l, ","); len(sp) == exp {
-		return sp, nil
 	}
-	return nil, errors.New(errString)
+	return nil
 }
 
-func readInEmailer(in*Input) (*Emailer, error) {
-	auths, err := readLine(in, 4, "Need four fields in the first line of the input file: identity,username,password,host")
-	if err != nil {
-		return nil, err
+type Emailer struct {
+	transport Transport
+	authn Authenticator
+	journal *Journal
+	from string
+	fromAddr mail.Address
+	subject string
+	htmlMsg string
+	textMsg string
+	replyTo string
+}
+
+// formatReplyTo renders a config Reply-To value ("address" or "Name
+// <address>") as a header-ready string, returning raw unchanged if it
+// doesn't parse as an address so a typo doesn't become a silent drop.
+func formatReplyTo(raw string) string {
+	if raw == "" {
+		return ""
 	}
-	sp, err := readLine(in, 2, "Need two fields in the second line of the input file: server,from")
+	addr, err := mail.ParseAddress(raw)
 	if err != nil {
-		return nil, err
+		return raw
 	}
-	subject, err := readLine(in, -1, "Need a line designating the subject in the third line of the file")
+	return addr.String()
+}
+
+// emailerFromConfig builds an Emailer from the validated SMTP/Message
+// sections of cfg.
+func emailerFromConfig(cfg *config.Config) (*Emailer, error) {
+	authn, err := buildAuthenticatorForConfig(cfg.SMTP)
 	if err != nil {
 		return nil, err
 	}
-	msg, err := readLine(in, -1, "Need a line designating the generic message in the fourth line of the file")
+
+	server := cfg.SMTP.Host
+	if cfg.SMTP.Port != 0 {
+		server = fmt.Sprintf("%s:%d", cfg.SMTP.Host, cfg.SMTP.Port)
+	}
+	transport, err := newTransport(cfg.SMTP.Mode, authn, cfg.SMTP.Host, server, cfg.SMTP.SendmailBin)
 	if err != nil {
 		return nil, err
 	}
-	
-	raw := "Subject: " + subject[0] + "\r\nContent-Type: text/html\r\n\r\n" + msg[0]
-	
-	if line, b := in.Next(); b {
-		return nil, errors.New("Extra line at the end of the first section: " + line)
-	}
-	
-	return &Emailer {
-		auth: smtp.PlainAuth(auths[0], auths[1], auths[2], auths[3]),
-		server: sp[0],
-		from: sp[1],
-		rawMsg: raw,
-	}, nil
-}
-
-
-
 
+	from := cfg.SMTP.From
+	if from == "" {
+		from = cfg.SMTP.Username
+	}
 
+	htmlMsg, textMsg := cfg.Message.HTML, cfg.Message.Text
+	if textMsg == "" {
+		textMsg = derivePlainText(htmlMsg)
+	}
 
+	// transport/authn are wrapped so a later SIGHUP (see installReloadHandler)
+	// can swap in freshly built ones without restarting BatchSend's workers.
+	return &Emailer{
+		transport: &reloadableTransport{t: transport},
+		authn: &reloadableAuthn{a: authn},
+		from: from,
+		fromAddr: mail.Address{Name: cfg.SMTP.FromName, Address: from},
+		subject: cfg.Message.Subject,
+		htmlMsg: htmlMsg,
+		textMsg: textMsg,
+		replyTo: formatReplyTo(cfg.Message.ReplyTo),
+	}, nil
+}
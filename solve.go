@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"math/bits"
+	"math/rand"
+	"sort"
+)
+
+// People sorts by name, giving a deterministic order to index people 0..N-1
+// for the csp below, so a given seed reproduces the same matching.
+type People []*Person
+
+func (p People) Len() int { return len(p) }
+func (p People) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p People) Less(i, j int) bool { return p[i].name < p[j].name }
+
+// csp is the bipartite giver->receiver constraint problem: people[i] may
+// give to any receiver j whose bit is set in domains[i]. Using a big.Int
+// bitset instead of a []*Person slice makes "is j still in i's domain" and
+// "remove j from i's domain" both cheap, which matters once forward
+// checking is clearing bits out of many domains per assignment.
+type csp struct {
+	people  []*Person
+	domains []*big.Int
+}
+
+// buildCSP indexes people deterministically and builds each person's
+// initial domain, excluding themselves and anyone in a shared BadSet.
+func buildCSP(people map[string]*Person, bad []*BadSet) *csp {
+	badMap := compileBadSet(bad)
+
+	ordered := make([]*Person, 0, len(people))
+	for _, v := range people {
+		ordered = append(ordered, v)
+	}
+	sort.Sort(People(ordered))
+
+	domains := make([]*big.Int, len(ordered))
+	for i, p := range ordered {
+		bset := badMap[p]
+		dom := new(big.Int)
+		for j, q := range ordered {
+			if bset == nil {
+				if q == p {
+					continue
+				}
+			} else if bset.IsIn(q) {
+				continue
+			}
+			dom.SetBit(dom, j, 1)
+		}
+		domains[i] = dom
+		fmt.Println("Domain for", p, ":", domainNames(dom, ordered))
+	}
+
+	return &csp{people: ordered, domains: domains}
+}
+
+func domainNames(dom *big.Int, people []*Person) []*Person {
+	ret := make([]*Person, 0, popcount(dom))
+	for i, p := range people {
+		if dom.Bit(i) == 1 {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
+func popcount(b *big.Int) int {
+	count := 0
+	for _, w := range b.Bits() {
+		count += bits.OnesCount(uint(w))
+	}
+	return count
+}
+
+// hallFeasible runs a practical (not exhaustive) Hall's-theorem check: for
+// every k, the k givers with the smallest domains must have a combined
+// domain of at least k receivers between them. This is a necessary
+// condition for a perfect matching to exist, and catches most infeasible
+// BadSet configurations (e.g. an exclusion clique bigger than the group can
+// absorb) immediately, rather than after a long failing search. It is not a
+// full decision procedure for every pathological case.
+func hallFeasible(domains []*big.Int) bool {
+	n := len(domains)
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return popcount(domains[order[a]]) < popcount(domains[order[b]])
+	})
+
+	union := new(big.Int)
+	for k, i := range order {
+		union.Or(union, domains[i])
+		if popcount(union) < k+1 {
+			return false
+		}
+	}
+	return true
+}
+
+// solve runs forward-checking backtracking search over c: at each step it
+// picks the unassigned giver with the fewest remaining candidate receivers
+// (the MRV heuristic), ties broken using r so a given seed reproduces the
+// same matching, assigns one of its candidates, and propagates the removal
+// of that receiver from every other unassigned giver's domain, undoing on
+// backtrack if any domain goes empty. Returns nil if no matching exists.
+func solve(c *csp, r *rand.Rand) map[*Person]*Person {
+	n := len(c.people)
+	domains := make([]*big.Int, n)
+	for i, d := range c.domains {
+		domains[i] = new(big.Int).Set(d)
+	}
+
+	assignedTo := make([]int, n) // receiver index given by giver i, or -1
+	for i := range assignedTo {
+		assignedTo[i] = -1
+	}
+	receiverTaken := make([]bool, n)
+
+	var backtrack func(remaining int) bool
+	backtrack = func(remaining int) bool {
+		if remaining == 0 {
+			return true
+		}
+
+		giver := pickMRV(domains, assignedTo, r)
+		if giver == -1 {
+			return false
+		}
+
+		for _, recv := range candidateOrder(domains[giver], r) {
+			if receiverTaken[recv] {
+				continue
+			}
+
+			assignedTo[giver] = recv
+			receiverTaken[recv] = true
+
+			cleared := make([]int, 0, n)
+			ok := true
+			for j := 0; j < n; j++ {
+				if assignedTo[j] != -1 || j == giver || domains[j].Bit(recv) == 0 {
+					continue
+				}
+				domains[j].SetBit(domains[j], recv, 0)
+				cleared = append(cleared, j)
+				if popcount(domains[j]) == 0 {
+					ok = false
+				}
+			}
+
+			if ok && backtrack(remaining-1) {
+				return true
+			}
+
+			for _, j := range cleared {
+				domains[j].SetBit(domains[j], recv, 1)
+			}
+			assignedTo[giver] = -1
+			receiverTaken[recv] = false
+		}
+		return false
+	}
+
+	if !backtrack(n) {
+		return nil
+	}
+
+	ret := make(map[*Person]*Person, n)
+	for i, p := range c.people {
+		ret[p] = c.people[assignedTo[i]]
+	}
+	return ret
+}
+
+// pickMRV returns the unassigned giver with the smallest remaining domain,
+// breaking ties using r, or -1 if every giver is already assigned.
+func pickMRV(domains []*big.Int, assignedTo []int, r *rand.Rand) int {
+	bestSize := -1
+	var tied []int
+	for i, a := range assignedTo {
+		if a != -1 {
+			continue
+		}
+		size := popcount(domains[i])
+		switch {
+		case bestSize == -1 || size < bestSize:
+			bestSize = size
+			tied = tied[:0]
+			tied = append(tied, i)
+		case size == bestSize:
+			tied = append(tied, i)
+		}
+	}
+	if len(tied) == 0 {
+		return -1
+	}
+	return tied[r.Intn(len(tied))]
+}
+
+// candidateOrder returns the set bits of domain in a random (seeded)
+// order, so which satisfiable assignment is found first is reproducible
+// but not simply the lowest index every time.
+func candidateOrder(domain *big.Int, r *rand.Rand) []int {
+	cand := make([]int, 0, popcount(domain))
+	for i := 0; i < domain.BitLen(); i++ {
+		if domain.Bit(i) == 1 {
+			cand = append(cand, i)
+		}
+	}
+	for i := len(cand) - 1; i > 0; i-- {
+		j := r.Intn(i + 1)
+		cand[i], cand[j] = cand[j], cand[i]
+	}
+	return cand
+}
+
+func makeRand(seed int64) *rand.Rand {
+	return rand.New(rand.NewSource(seed))
+}
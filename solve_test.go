@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func makeTestPeople(n int) map[string]*Person {
+	people := make(map[string]*Person, n)
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("p%03d", i)
+		people[name] = &Person{name: name, email: name + "@example.com"}
+	}
+	return people
+}
+
+func badSetOf(people map[string]*Person, names ...string) *BadSet {
+	s := NewBadSet()
+	for _, n := range names {
+		s.AddPerson(people[n])
+	}
+	return s
+}
+
+func verifyMatching(t *testing.T, c *csp, solution map[*Person]*Person) {
+	t.Helper()
+	if len(solution) != len(c.people) {
+		t.Fatalf("matching has %d entries, want %d", len(solution), len(c.people))
+	}
+
+	index := make(map[*Person]int, len(c.people))
+	for i, p := range c.people {
+		index[p] = i
+	}
+
+	receivers := make(map[*Person]bool, len(c.people))
+	for giver, receiver := range solution {
+		if receivers[receiver] {
+			t.Fatalf("%v received more than one gift", receiver)
+		}
+		receivers[receiver] = true
+
+		gi, ri := index[giver], index[receiver]
+		if c.domains[gi].Bit(ri) == 0 {
+			t.Fatalf("%v -> %v is not a legal assignment", giver, receiver)
+		}
+	}
+}
+
+func TestSolveSmallNoBadSets(t *testing.T) {
+	people := makeTestPeople(5)
+	c := buildCSP(people, nil)
+	if !hallFeasible(c.domains) {
+		t.Fatal("expected a feasible matching with no BadSets")
+	}
+	solution := solve(c, makeRand(1))
+	if solution == nil {
+		t.Fatal("expected a matching")
+	}
+	verifyMatching(t, c, solution)
+}
+
+func TestSolveDenseBadSets(t *testing.T) {
+	const n = 300
+	people := makeTestPeople(n)
+
+	// Partition into groups of 3 that may not draw each other - dense but
+	// still solvable, since it leaves n-3 legal receivers per person.
+	names := make([]string, 0, n)
+	for name := range people {
+		names = append(names, name)
+	}
+	var bads []*BadSet
+	for i := 0; i+3 <= len(names); i += 3 {
+		bads = append(bads, badSetOf(people, names[i], names[i+1], names[i+2]))
+	}
+
+	c := buildCSP(people, bads)
+	if !hallFeasible(c.domains) {
+		t.Fatal("expected dense-but-solvable BadSets to pass the feasibility check")
+	}
+	solution := solve(c, makeRand(42))
+	if solution == nil {
+		t.Fatal("expected a matching for dense BadSets")
+	}
+	verifyMatching(t, c, solution)
+}
+
+func TestSolveReproducible(t *testing.T) {
+	people := makeTestPeople(50)
+	c := buildCSP(people, nil)
+
+	a := solve(c, makeRand(7))
+	b := solve(c, makeRand(7))
+	for giver, receiver := range a {
+		if b[giver] != receiver {
+			t.Fatalf("same seed produced different matchings for %v: %v vs %v", giver, receiver, b[giver])
+		}
+	}
+}
+
+func TestHallInfeasible(t *testing.T) {
+	people := makeTestPeople(4)
+
+	all := NewBadSet()
+	for _, p := range people {
+		all.AddPerson(p)
+	}
+
+	c := buildCSP(people, []*BadSet{all})
+	if hallFeasible(c.domains) {
+		t.Fatal("expected infeasibility when everyone excludes everyone, including themselves")
+	}
+}
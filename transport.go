@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/smtp"
+	"os/exec"
+	"strings"
+)
+
+// Transport knows how to hand a fully composed RFC 5322 message to some
+// delivery mechanism: a remote SMTP server or a local MTA.
+type Transport interface {
+	Send(from string, to []string, msg []byte) error
+}
+
+// plainTransport dials in the clear and upgrades with STARTTLS only if the
+// server advertises it, mirroring net/smtp's SendMail. This is the mode the
+// tool has always used, and stays the default when santa.txt doesn't
+// specify one.
+type plainTransport struct {
+	authn  Authenticator
+	server string
+	host   string
+}
+
+func (t *plainTransport) Send(from string, to []string, msg []byte) error {
+	c, err := smtp.Dial(t.server)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: t.host}); err != nil {
+			return err
+		}
+	}
+	return deliver(c, t.authn, from, to, msg)
+}
+
+// tlsTransport dials straight into implicit TLS (e.g. port 465) before
+// speaking any SMTP at all.
+type tlsTransport struct {
+	authn      Authenticator
+	server     string
+	serverName string
+}
+
+func (t *tlsTransport) Send(from string, to []string, msg []byte) error {
+	conn, err := tls.Dial("tcp", t.server, &tls.Config{ServerName: t.serverName})
+	if err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, t.serverName)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return deliver(c, t.authn, from, to, msg)
+}
+
+// starttlsTransport dials in the clear and upgrades with STARTTLS before
+// authenticating, for servers that reject plaintext AUTH outright.
+type starttlsTransport struct {
+	authn      Authenticator
+	server     string
+	serverName string
+}
+
+func (t *starttlsTransport) Send(from string, to []string, msg []byte) error {
+	c, err := smtp.Dial(t.server)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if err := c.StartTLS(&tls.Config{ServerName: t.serverName}); err != nil {
+		return err
+	}
+	return deliver(c, t.authn, from, to, msg)
+}
+
+// deliver runs the AUTH/MAIL/RCPT/DATA sequence against an already-connected
+// (and, if required, already-STARTTLS'd) client. authn.Auth() is asked for
+// credentials on every call, so a refreshed OAuth2 token is picked up
+// without needing to rebuild the Transport.
+func deliver(c *smtp.Client, authn Authenticator, from string, to []string, msg []byte) error {
+	if authn != nil {
+		if ok, _ := c.Extension("AUTH"); ok {
+			auth, err := authn.Auth()
+			if err != nil {
+				return err
+			}
+			if err := c.Auth(auth); err != nil {
+				return err
+			}
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// sendmailTransport pipes the rendered message to a local MTA binary, like
+// picolist does, so the tool is useful on hosts that only have a local
+// sendmail and no SMTP credentials at all.
+type sendmailTransport struct {
+	bin string
+}
+
+func (t *sendmailTransport) Send(from string, to []string, msg []byte) error {
+	if err := rejectFlagLikeAddr(from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := rejectFlagLikeAddr(addr); err != nil {
+			return err
+		}
+	}
+
+	bin := t.bin
+	if bin == "" {
+		bin = "/usr/sbin/sendmail"
+	}
+	// "--" stops sendmail from parsing any of the addresses as a flag, on
+	// top of the rejectFlagLikeAddr check above.
+	args := append([]string{"-i", "-f", from, "--"}, to...)
+	cmd := exec.Command(bin, args...)
+	cmd.Stdin = strings.NewReader(string(msg))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("sendmail: %v: %s", err, out)
+	}
+	return nil
+}
+
+// rejectFlagLikeAddr reports an error if addr starts with "-", which
+// sendmailTransport's exec.Command would otherwise hand to sendmail as a
+// flag instead of an address.
+func rejectFlagLikeAddr(addr string) error {
+	if strings.HasPrefix(addr, "-") {
+		return fmt.Errorf("refusing to pass %q to sendmail: looks like a flag, not an address", addr)
+	}
+	return nil
+}
+
+// newTransport builds the Transport selected by mode, using authn for
+// whichever of them actually authenticate and host/server for the other
+// modes. mode is one of "" (meaning "plain"), "plain", "starttls", "tls", or
+// "sendmail"; for "sendmail", host/server are ignored and sendmailBin is
+// used instead (empty meaning sendmailTransport's own "/usr/sbin/sendmail"
+// default).
+func newTransport(mode string, authn Authenticator, host, server, sendmailBin string) (Transport, error) {
+	switch mode {
+	case "", "plain":
+		return &plainTransport{authn: authn, server: server, host: host}, nil
+	case "tls":
+		return &tlsTransport{authn: authn, server: server, serverName: host}, nil
+	case "starttls":
+		return &starttlsTransport{authn: authn, server: server, serverName: host}, nil
+	case "sendmail":
+		return &sendmailTransport{bin: sendmailBin}, nil
+	default:
+		return nil, fmt.Errorf("unknown smtp mode %q: must be plain, starttls, tls, or sendmail", mode)
+	}
+}